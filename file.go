@@ -0,0 +1,338 @@
+package matlab
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	headerLen                = 128
+	headerTextLen            = 116
+	headerSubsystemOffsetLen = 8
+	headerFlagLen            = 4
+)
+
+// File represents a .mat matlab file.
+//
+// A File created with Open or NewFile is backed by an io.ReaderAt and is
+// indexed once, up front, into Variables; individual variables are only
+// decoded on demand via Variable.Data, so a single named variable can be
+// pulled out of a multi-gigabyte .mat file without reading the rest. A File
+// created with NewFileFromReader has no such index and only supports
+// sequential reading via ReadElement.
+type File struct {
+	Header    *Header
+	Variables []*Variable
+
+	r      io.Reader
+	ra     io.ReaderAt
+	w      io.Writer
+	closer io.Closer
+}
+
+// Header is a matlab .mat file header
+type Header struct {
+	Level     string
+	Platform  string
+	Created   time.Time
+	Endianess binary.ByteOrder
+}
+
+// String implements the stringer interface for Header
+// with the standard .mat file prefix (without the filler bytes)
+func (h *Header) String() string {
+	return fmt.Sprintf("MATLAB %s MAT-file, Platform: %s, Created on: %s", h.Level, h.Platform, h.Created.Format(time.ANSIC))
+}
+
+// Variable describes a top-level miMATRIX element indexed from a .mat file.
+// It records where the element lives in the underlying file without
+// decoding its contents; call Open or Data to read it.
+type Variable struct {
+	Name  string
+	Class mxClass
+
+	f      *File
+	typ    DataType // DTmiMATRIX or DTmiCOMPRESSED
+	offset int64    // offset of the element's raw data, after its tag
+	length int64    // length of the element's raw data, as reported by its tag
+}
+
+// Open returns an io.ReadSeeker over the Variable's raw miMATRIX payload
+// (array flags, dimensions, name, and data sub-elements), transparently
+// inflating it first if it was stored as a miCOMPRESSED element. Callers
+// that only want the decoded value should use Data instead.
+func (v *Variable) Open() (io.ReadSeeker, error) {
+	sr := io.NewSectionReader(v.f.ra, v.offset, v.length)
+	if v.typ != DTmiCOMPRESSED {
+		return sr, nil
+	}
+
+	zr, err := zlib.NewReader(sr)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	buf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := stripMatrixTag(v.f.Header.Endianess, buf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(body), nil
+}
+
+// Data reads and decodes the Variable, returning the same value ReadElement
+// would have produced for its miMATRIX element.
+func (v *Variable) Data() (interface{}, error) {
+	r, err := v.Open()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return miMatrix(v.f.Header.Endianess, buf)
+}
+
+// stripMatrixTag reads a single element's tag off the front of buf and
+// returns the remaining data bytes, used to unwrap the miMATRIX element
+// nested inside an inflated miCOMPRESSED payload.
+func stripMatrixTag(bo binary.ByteOrder, buf []byte) ([]byte, error) {
+	r := bytes.NewReader(buf)
+	el, p, _, err := newBinaryReader(bo, r).readTag()
+	if err != nil {
+		return nil, err
+	}
+	if el.Type != DTmiMATRIX {
+		return nil, fmt.Errorf("matlab: compressed element does not contain a matrix")
+	}
+	start := len(buf) - r.Len()
+	if p < 0 || start+p > len(buf) {
+		return nil, fmt.Errorf("matlab: compressed matrix element declares length %d beyond inflated payload of %d bytes", p, len(buf)-start)
+	}
+	return buf[start : start+p], nil
+}
+
+// NewFileFromReader creates a file from a reader and attempts to read
+// the header. The returned File only supports sequential access via
+// ReadElement; use NewFile or Open for random access to Variables.
+func NewFileFromReader(r io.Reader) (f *File, err error) {
+	f = &File{r: r}
+	err = f.readHeader(r)
+	return
+}
+
+// NewFile creates a File for random access to the MAT-file held in r,
+// mirroring debug/elf.NewFile. It reads the header and then performs a
+// single index pass, recording the offset, length, class, and name of
+// every top-level variable into File.Variables without decoding any of
+// their data.
+func NewFile(r io.ReaderAt) (f *File, err error) {
+	f = &File{ra: r}
+	if err = f.readHeader(io.NewSectionReader(r, 0, headerLen)); err != nil {
+		return nil, err
+	}
+	if err = f.index(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open opens the named file using os.Open and prepares it for random
+// access via NewFile, mirroring debug/elf.Open. The file is closed when
+// the returned File's Close method is called.
+func Open(name string) (*File, error) {
+	osf, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFile(osf)
+	if err != nil {
+		osf.Close()
+		return nil, err
+	}
+	f.closer = osf
+	return f, nil
+}
+
+// Close closes the File. If the File was created using NewFile or
+// NewFileFromReader directly, rather than Open, Close has no effect.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	err := f.closer.Close()
+	f.closer = nil
+	return err
+}
+
+// index walks every top-level element in the file starting just after the
+// header, recording miMATRIX and miCOMPRESSED elements as Variables.
+func (f *File) index() error {
+	bo := f.Header.Endianess
+	offset := int64(headerLen)
+
+	for {
+		sr := io.NewSectionReader(f.ra, offset, math.MaxInt64-offset)
+		el, p, _, err := newBinaryReader(bo, sr).readTag()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		body := offset + 8
+		v := &Variable{f: f, typ: el.Type, offset: body, length: int64(p)}
+		if el.Type == DTmiMATRIX || el.Type == DTmiCOMPRESSED {
+			if err := v.peekHeader(); err != nil {
+				return err
+			}
+			f.Variables = append(f.Variables, v)
+		}
+
+		offset = body + pad8(int64(p))
+	}
+}
+
+// peekHeader decodes just enough of a Variable's element - its array flags,
+// dimensions, and name - to populate Class and Name, without reading the
+// remainder of its (possibly compressed) data.
+func (v *Variable) peekHeader() error {
+	bo := v.f.Header.Endianess
+
+	var r io.Reader = io.NewSectionReader(v.f.ra, v.offset, v.length)
+	if v.typ == DTmiCOMPRESSED {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		el, p, _, err := newBinaryReader(bo, zr).readTag()
+		if err != nil {
+			return err
+		}
+		if el.Type != DTmiMATRIX {
+			return fmt.Errorf("matlab: compressed element does not contain a matrix")
+		}
+		r = io.LimitReader(zr, int64(p))
+	}
+
+	br := newBinaryReader(bo, r)
+	flags, err := arrayFlags(br)
+	if err != nil {
+		return err
+	}
+	if _, err := dimensionsArray(br); err != nil {
+		return err
+	}
+	name, err := arrayName(br)
+	if err != nil {
+		return err
+	}
+
+	v.Class = flags.class
+	v.Name = name
+	return nil
+}
+
+// pad8 returns n rounded up to the next 8-byte boundary, matching the
+// padding every MAT-file data element carries after its payload.
+func pad8(n int64) int64 {
+	if rem := n % 8; rem != 0 {
+		return n + (8 - rem)
+	}
+	return n
+}
+
+func (f *File) readHeader(r io.Reader) (err error) {
+	var buf []byte
+	h := &Header{}
+	f.Header = h
+
+	// read description
+	buf = make([]byte, headerTextLen)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+
+	br := bufio.NewReader(bytes.NewBuffer(buf))
+
+	if prefix, err := br.ReadBytes(' '); err != nil {
+		return err
+	} else if !bytes.Equal(prefix, []byte("MATLAB ")) {
+		return fmt.Errorf("not a valid .mat file")
+	}
+
+	if h.Level, err = br.ReadString(' '); err != nil {
+		return err
+	}
+
+	h.Level = strings.TrimSpace(h.Level)
+	if h.Level != "5.0" {
+		return fmt.Errorf("can only read matlab level 5 files")
+	}
+
+	if _, err = br.Discard(len("MAT-file Platform: ")); err != nil {
+		return
+	}
+
+	if h.Platform, err = br.ReadString(','); err != nil {
+		return
+	}
+	h.Platform = strings.TrimRight(h.Platform, ",")
+
+	if _, err = br.Discard(len(" Created on: ")); err != nil {
+		return
+	}
+
+	date := make([]byte, 24)
+	if _, err = br.Read(date); err != nil {
+		return
+	}
+	if h.Created, err = time.Parse(time.ANSIC, strings.TrimSpace(string(date))); err != nil {
+		return
+	}
+
+	if _, err = io.CopyN(ioutil.Discard, r, headerSubsystemOffsetLen); err != nil {
+		return
+	}
+
+	buf = make([]byte, headerFlagLen)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+
+	byteOrder := string(buf[2:4])
+	if byteOrder == "MI" {
+		h.Endianess = binary.BigEndian
+	} else if byteOrder == "IM" {
+		h.Endianess = binary.LittleEndian
+	} else {
+		return fmt.Errorf("invalid byte order setting: %s", byteOrder)
+	}
+
+	return nil
+}
+
+// ReadElement reads a single Element from a file's reader. It is only
+// valid for a File created with NewFileFromReader; files created with
+// NewFile or Open should use Variables instead.
+func (f *File) ReadElement() (el *Element, err error) {
+	return readElement(f.Header.Endianess, f.r)
+}
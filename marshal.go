@@ -0,0 +1,478 @@
+package matlab
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Unmarshal decodes el into v, which must be a non-nil pointer. Struct
+// fields are matched to mxSTRUCT fields by name, or by a `matlab:"name"`
+// struct tag when present. Numeric MATLAB arrays decode into Go slices or
+// (dimension-checked) arrays, mxCHAR into string, mxCELL into []interface{}
+// or a typed slice, and mxSTRUCT into a nested struct or map[string]T.
+func Unmarshal(el *Element, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("matlab: Unmarshal target must be a non-nil pointer")
+	}
+	return unmarshalValue(el, rv.Elem())
+}
+
+func unmarshalValue(el *Element, rv reflect.Value) error {
+	switch el.Class {
+	case mxSTRUCT:
+		return unmarshalStruct(el, rv)
+	case mxCHAR:
+		s, _ := el.Value.(string)
+		return assignString(rv, s)
+	case mxCELL:
+		return unmarshalCell(el, rv)
+	default:
+		return assignNumeric(rv, el)
+	}
+}
+
+// fieldName returns the `matlab:"..."` tag value for sf, or its Go name.
+func fieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("matlab"); tag != "" {
+		return tag
+	}
+	return sf.Name
+}
+
+func unmarshalStruct(el *Element, rv reflect.Value) error {
+	st, ok := el.Value.(*Struct)
+	if !ok {
+		return fmt.Errorf("matlab: element is not a struct")
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			sf := rt.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			fel, ok := st.Fields[fieldName(sf)]
+			if !ok {
+				continue
+			}
+			if err := unmarshalValue(fel, rv.Field(i)); err != nil {
+				return fmt.Errorf("matlab: field %s: %s", sf.Name, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for _, name := range st.Order {
+			ev := reflect.New(rv.Type().Elem()).Elem()
+			if err := unmarshalValue(st.Fields[name], ev); err != nil {
+				return fmt.Errorf("matlab: field %s: %s", name, err)
+			}
+			rv.SetMapIndex(reflect.ValueOf(name), ev)
+		}
+		return nil
+	case reflect.Interface:
+		v, err := decodeInterface(el)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	default:
+		return fmt.Errorf("matlab: cannot unmarshal struct into %s", rv.Type())
+	}
+}
+
+func unmarshalCell(el *Element, rv reflect.Value) error {
+	els, ok := el.Value.([]*Element)
+	if !ok {
+		return fmt.Errorf("matlab: element is not a cell array")
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		v, err := decodeInterface(el)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	case reflect.Slice:
+		out := reflect.MakeSlice(rv.Type(), len(els), len(els))
+		for i, e := range els {
+			if err := unmarshalValue(e, out.Index(i)); err != nil {
+				return fmt.Errorf("matlab: cell %d: %s", i, err)
+			}
+		}
+		rv.Set(out)
+		return nil
+	default:
+		return fmt.Errorf("matlab: cannot unmarshal cell array into %s", rv.Type())
+	}
+}
+
+// decodeInterface recursively decodes el into plain interface{} values
+// (map[string]interface{}, []interface{}, string, or a typed slice), for
+// use when the Unmarshal target doesn't name a concrete Go type.
+func decodeInterface(el *Element) (interface{}, error) {
+	switch el.Class {
+	case mxSTRUCT:
+		st, ok := el.Value.(*Struct)
+		if !ok {
+			return nil, fmt.Errorf("matlab: element is not a struct")
+		}
+		m := make(map[string]interface{}, len(st.Order))
+		for _, name := range st.Order {
+			v, err := decodeInterface(st.Fields[name])
+			if err != nil {
+				return nil, err
+			}
+			m[name] = v
+		}
+		return m, nil
+	case mxCELL:
+		els, ok := el.Value.([]*Element)
+		if !ok {
+			return nil, fmt.Errorf("matlab: element is not a cell array")
+		}
+		out := make([]interface{}, len(els))
+		for i, e := range els {
+			v, err := decodeInterface(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	default:
+		return el.Value, nil
+	}
+}
+
+func assignString(rv reflect.Value, s string) error {
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+		return nil
+	case reflect.Interface:
+		rv.Set(reflect.ValueOf(s))
+		return nil
+	default:
+		return fmt.Errorf("matlab: cannot unmarshal character array into %s", rv.Type())
+	}
+}
+
+func assignNumeric(rv reflect.Value, el *Element) error {
+	switch el.Value.(type) {
+	case *Complex:
+		return fmt.Errorf("matlab: complex arrays are not supported by Unmarshal")
+	case *Sparse:
+		return fmt.Errorf("matlab: sparse arrays are not supported by Unmarshal")
+	}
+	return assignSlice(rv, reflect.ValueOf(el.Value), el.Dims)
+}
+
+// assignSlice converts the decoded, typed source slice src into rv,
+// dimension-checking when rv is a fixed-size Go array.
+func assignSlice(rv reflect.Value, src reflect.Value, dims []int32) error {
+	n := src.Len()
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		rv.Set(src)
+		return nil
+	case reflect.Array:
+		if rv.Len() != n {
+			return fmt.Errorf("matlab: dimension mismatch: array has %d elements, target has %d", n, rv.Len())
+		}
+		for i := 0; i < n; i++ {
+			if err := assignNumericElem(rv.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		out := reflect.MakeSlice(rv.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := assignNumericElem(out.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(out)
+		return nil
+	default:
+		if n != 1 {
+			return fmt.Errorf("matlab: cannot unmarshal %d-element array into %s", n, rv.Type())
+		}
+		return assignNumericElem(rv, src.Index(0))
+	}
+}
+
+func assignNumericElem(dst, src reflect.Value) error {
+	switch {
+	case dst.Kind() == reflect.Interface:
+		dst.Set(src)
+	case src.Type().ConvertibleTo(dst.Type()):
+		dst.Set(src.Convert(dst.Type()))
+	default:
+		return fmt.Errorf("matlab: cannot convert %s to %s", src.Type(), dst.Type())
+	}
+	return nil
+}
+
+// Marshal encodes v into an *Element suitable for writing to a .mat file.
+// Structs and map[string]T encode as mxSTRUCT (fields named by a
+// `matlab:"name"` tag, or the Go field name), strings as mxCHAR, bools and
+// []bool as logical arrays, and other slices/arrays as a numeric mxClass
+// array or, when their element type is a string, struct, map, interface,
+// pointer, slice or array, an mxCELL. An empty numeric slice encodes as a
+// 0x0 mxDOUBLE array.
+func Marshal(v interface{}) (*Element, error) {
+	return marshalValue(reflect.ValueOf(v))
+}
+
+func marshalValue(rv reflect.Value) (*Element, error) {
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("matlab: cannot marshal nil value")
+	}
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("matlab: cannot marshal nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return marshalStruct(rv)
+	case reflect.Map:
+		return marshalMap(rv)
+	case reflect.String:
+		return marshalString(rv.String()), nil
+	case reflect.Bool:
+		return marshalLogical([]bool{rv.Bool()}, []int32{1, 1}), nil
+	case reflect.Slice, reflect.Array:
+		return marshalSliceOrArray(rv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return marshalScalar(rv)
+	default:
+		return nil, fmt.Errorf("matlab: cannot marshal value of kind %s", rv.Kind())
+	}
+}
+
+func marshalScalar(rv reflect.Value) (*Element, error) {
+	class, value, err := numericClassAndValue(rv)
+	if err != nil {
+		return nil, err
+	}
+	return &Element{Type: DTmiMATRIX, Class: class, Dims: []int32{1, 1}, Value: value}, nil
+}
+
+// numericClassAndValue maps a single Go numeric value to its mxClass and a
+// one-element typed slice holding it.
+func numericClassAndValue(rv reflect.Value) (mxClass, interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Float64:
+		return mxDOUBLE, []float64{rv.Float()}, nil
+	case reflect.Float32:
+		return mxSINGLE, []float32{float32(rv.Float())}, nil
+	case reflect.Int8:
+		return mxINT8, []int8{int8(rv.Int())}, nil
+	case reflect.Uint8:
+		return mxUINT8, []uint8{uint8(rv.Uint())}, nil
+	case reflect.Int16:
+		return mxINT16, []int16{int16(rv.Int())}, nil
+	case reflect.Uint16:
+		return mxUINT16, []uint16{uint16(rv.Uint())}, nil
+	case reflect.Int32, reflect.Int:
+		return mxINT32, []int32{int32(rv.Int())}, nil
+	case reflect.Uint32, reflect.Uint:
+		return mxUINT32, []uint32{uint32(rv.Uint())}, nil
+	case reflect.Int64:
+		return mxINT64, []int64{rv.Int()}, nil
+	case reflect.Uint64:
+		return mxUINT64, []uint64{rv.Uint()}, nil
+	default:
+		return 0, nil, fmt.Errorf("matlab: unsupported numeric kind: %s", rv.Kind())
+	}
+}
+
+func marshalLogical(v []bool, dims []int32) *Element {
+	return &Element{Type: DTmiMATRIX, Class: mxUINT8, Logical: true, Dims: dims, Value: v}
+}
+
+func marshalString(s string) *Element {
+	return &Element{Type: DTmiMATRIX, Class: mxCHAR, Dims: []int32{1, int32(len(s))}, Value: s}
+}
+
+func marshalSliceOrArray(rv reflect.Value) (*Element, error) {
+	n := rv.Len()
+	dims := []int32{1, int32(n)}
+
+	if n > 0 {
+		switch rv.Type().Elem().Kind() {
+		case reflect.Bool:
+			out := make([]bool, n)
+			for i := range out {
+				out[i] = rv.Index(i).Bool()
+			}
+			return marshalLogical(out, dims), nil
+		case reflect.Struct, reflect.Map, reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Array, reflect.String:
+			return marshalCell(rv, dims)
+		}
+	}
+
+	return marshalNumericSlice(rv, dims)
+}
+
+func marshalCell(rv reflect.Value, dims []int32) (*Element, error) {
+	els := make([]*Element, rv.Len())
+	for i := range els {
+		el, err := marshalValue(rv.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("matlab: cell %d: %s", i, err)
+		}
+		els[i] = el
+	}
+	return &Element{Type: DTmiMATRIX, Class: mxCELL, Dims: dims, Value: els}, nil
+}
+
+func marshalNumericSlice(rv reflect.Value, dims []int32) (*Element, error) {
+	n := rv.Len()
+	if n == 0 {
+		return &Element{Type: DTmiMATRIX, Class: mxDOUBLE, Dims: []int32{0, 0}, Value: []float64{}}, nil
+	}
+
+	class, _, err := numericClassAndValue(rv.Index(0))
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := numericSliceValue(class, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Element{Type: DTmiMATRIX, Class: class, Dims: dims, Value: value}, nil
+}
+
+// numericSliceValue converts rv element-by-element into the typed Go slice
+// matching class, mirroring the shapes numericData1 decodes.
+func numericSliceValue(class mxClass, rv reflect.Value) (interface{}, error) {
+	n := rv.Len()
+	switch class {
+	case mxDOUBLE:
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = rv.Index(i).Float()
+		}
+		return out, nil
+	case mxSINGLE:
+		out := make([]float32, n)
+		for i := range out {
+			out[i] = float32(rv.Index(i).Float())
+		}
+		return out, nil
+	case mxINT8:
+		out := make([]int8, n)
+		for i := range out {
+			out[i] = int8(rv.Index(i).Int())
+		}
+		return out, nil
+	case mxUINT8:
+		out := make([]uint8, n)
+		for i := range out {
+			out[i] = uint8(rv.Index(i).Uint())
+		}
+		return out, nil
+	case mxINT16:
+		out := make([]int16, n)
+		for i := range out {
+			out[i] = int16(rv.Index(i).Int())
+		}
+		return out, nil
+	case mxUINT16:
+		out := make([]uint16, n)
+		for i := range out {
+			out[i] = uint16(rv.Index(i).Uint())
+		}
+		return out, nil
+	case mxINT32:
+		out := make([]int32, n)
+		for i := range out {
+			out[i] = int32(rv.Index(i).Int())
+		}
+		return out, nil
+	case mxUINT32:
+		out := make([]uint32, n)
+		for i := range out {
+			out[i] = uint32(rv.Index(i).Uint())
+		}
+		return out, nil
+	case mxINT64:
+		out := make([]int64, n)
+		for i := range out {
+			out[i] = rv.Index(i).Int()
+		}
+		return out, nil
+	case mxUINT64:
+		out := make([]uint64, n)
+		for i := range out {
+			out[i] = rv.Index(i).Uint()
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("matlab: unsupported numeric class: %s", class)
+	}
+}
+
+func marshalStruct(rv reflect.Value) (*Element, error) {
+	rt := rv.Type()
+	st := &Struct{Fields: make(map[string]*Element), Order: make([]string, 0, rt.NumField())}
+
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := fieldName(sf)
+
+		el, err := marshalValue(rv.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("matlab: field %s: %s", sf.Name, err)
+		}
+		st.Fields[name] = el
+		st.Order = append(st.Order, name)
+	}
+
+	return &Element{Type: DTmiMATRIX, Class: mxSTRUCT, Dims: []int32{1, 1}, Value: st}, nil
+}
+
+func marshalMap(rv reflect.Value) (*Element, error) {
+	if rv.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("matlab: cannot marshal map with non-string keys")
+	}
+
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	st := &Struct{Fields: make(map[string]*Element, len(keys)), Order: make([]string, 0, len(keys))}
+	for _, k := range keys {
+		name := k.String()
+		el, err := marshalValue(rv.MapIndex(k))
+		if err != nil {
+			return nil, fmt.Errorf("matlab: field %s: %s", name, err)
+		}
+		st.Fields[name] = el
+		st.Order = append(st.Order, name)
+	}
+
+	return &Element{Type: DTmiMATRIX, Class: mxSTRUCT, Dims: []int32{1, 1}, Value: st}, nil
+}
@@ -2,14 +2,14 @@
 package matlab
 
 import (
-	"bufio"
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"strings"
-	"time"
+	"io/ioutil"
+	"math"
+	"unicode/utf16"
 )
 
 // DataType represents matlab data types
@@ -75,170 +75,149 @@ const (
 	DTmiUTF32      // Unicode UTF-32 Encoded Character Data
 )
 
-// File represents a .mat matlab file
-type File struct {
-	Header *Header
-	r      io.Reader
-	w      io.Writer
+// Element is a parsed matlab data element. For a decoded mxMATRIX (Type ==
+// DTmiMATRIX), Name, Dims, Class, Global, and Logical describe the array and
+// Value holds its decoded contents: a typed numeric slice (or *Complex, for
+// complex arrays), []bool for logicals, string for mxCHAR, []*Element for
+// mxCELL, *Struct for mxSTRUCT, or *Sparse for mxSPARSE.
+type Element struct {
+	Type    DataType
+	Value   interface{}
+	Name    string
+	Dims    []int32
+	Class   mxClass
+	Global  bool
+	Logical bool
 }
 
-// Header is a matlab .mat file header
-type Header struct {
-	Level     string
-	Platform  string
-	Created   time.Time
-	Endianess binary.ByteOrder
+// Complex holds the real and imaginary components of a complex numeric
+// array, each decoded to the same typed slice used for the real-only case.
+type Complex struct {
+	Real interface{}
+	Imag interface{}
 }
 
-// String implements the stringer interface for Header
-// with the standard .mat file prefix (without the filler bytes)
-func (h *Header) String() string {
-	return fmt.Sprintf("MATLAB %s MAT-file, Platform: %s, Created on: %s", h.Level, h.Platform, h.Created.Format(time.ANSIC))
+// Struct holds the decoded fields of a scalar (1x1) mxSTRUCT array. Order
+// preserves the field order declared in the file; Fields indexes the same
+// elements by name. Struct arrays with more than one element are rejected
+// with an error rather than decoded, since there is no single field-major
+// element to hold their per-field values.
+type Struct struct {
+	Fields map[string]*Element
+	Order  []string
 }
 
-// Element is a parsed matlab data element
-type Element struct {
-	Type  DataType
-	Value interface{}
+// Sparse holds a MATLAB sparse array in compressed-sparse-column form: ir
+// (row indices) and jc (column pointers) as defined by the MAT-file spec.
+type Sparse struct {
+	RowIdx []int32
+	ColPtr []int32
+	Real   interface{}
+	Imag   interface{}
 }
 
-// NewFileFromReader creates a file from a reader and attempts to read
-// the header
-func NewFileFromReader(r io.Reader) (f *File, err error) {
-	f = &File{r: r}
-	err = f.readHeader()
-	return
+// binaryReader wraps an io.Reader together with the byte order needed to
+// decode it, centralizing tag decoding, 8-byte alignment padding, and
+// bounds-checked reads in one place.
+type binaryReader struct {
+	r  io.Reader
+	bo binary.ByteOrder
 }
 
-const (
-	headerLen                = 128
-	headerTextLen            = 116
-	headerSubsystemOffsetLen = 8
-	headerFlagLen            = 4
-)
-
-func (f *File) readHeader() (err error) {
-	var buf []byte
-	h := &Header{}
-	f.Header = h
-
-	// read description
-	if buf, err = readAllBytes(headerTextLen, f.r); err != nil {
-		return
-	}
-
-	r := bufio.NewReader(bytes.NewBuffer(buf))
-
-	if prefix, err := r.ReadBytes(' '); err != nil {
-		return err
-	} else if !bytes.Equal(prefix, []byte("MATLAB ")) {
-		return fmt.Errorf("not a valid .mat file")
-	}
-
-	if h.Level, err = r.ReadString(' '); err != nil {
-		return err
-	}
+func newBinaryReader(bo binary.ByteOrder, r io.Reader) *binaryReader {
+	return &binaryReader{r: r, bo: bo}
+}
 
-	h.Level = strings.TrimSpace(h.Level)
-	if h.Level != "5.0" {
-		return fmt.Errorf("can only read matlab level 5 files")
+// readFull reads exactly n bytes, returning an error (including io.EOF and
+// io.ErrUnexpectedEOF) if the underlying reader runs out first.
+func (br *binaryReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return nil, err
 	}
+	return buf, nil
+}
 
-	if _, err = r.Discard(len("MAT-file Platform: ")); err != nil {
-		return
+// readTag reads a single element's 8-byte tag, reporting either the length
+// of a normal-form element (to be read separately) or, for a small-form
+// element, the raw bytes of its payload packed into the tag word itself.
+//
+// The MAT-file spec signals small form by the upper two bytes of the tag's
+// first 32-bit word being non-zero: those bytes hold the payload's length,
+// the lower two bytes hold its data type, and the (at most 4-byte) payload
+// occupies the tag's second word.
+func (br *binaryReader) readTag() (el *Element, n int, small []byte, err error) {
+	buf, err := br.readFull(8)
+	if err != nil {
+		return nil, 0, nil, err
 	}
 
-	if h.Platform, err = r.ReadString(','); err != nil {
-		return
+	word := br.bo.Uint32(buf[0:4])
+	if size := word >> 16; size != 0 {
+		if size > 4 {
+			return nil, 0, nil, fmt.Errorf("matlab: invalid small-form element size: %d", size)
+		}
+		return &Element{Type: DataType(word & 0xffff)}, int(size), buf[4:8], nil
 	}
-	h.Platform = strings.TrimRight(h.Platform, ",")
 
-	if _, err = r.Discard(len(" Created on: ")); err != nil {
-		return
-	}
+	el = &Element{Type: DataType(word)}
+	n = int(br.bo.Uint32(buf[4:8]))
+	return el, n, nil, nil
+}
 
-	date := make([]byte, 24)
-	if _, err = r.Read(date); err != nil {
-		return
+// readDataElement reads a single sub-element - its tag plus payload -
+// inside an already-decoded matrix body, handling both the small and
+// normal tag forms uniformly and leaving the reader positioned just past
+// any trailing padding.
+func (br *binaryReader) readDataElement() (DataType, []byte, error) {
+	el, p, small, err := br.readTag()
+	if err != nil {
+		return 0, nil, err
 	}
-	if h.Created, err = time.Parse(time.ANSIC, strings.TrimSpace(string(date))); err != nil {
-		return
+	if small != nil {
+		return el.Type, small[:p], nil
 	}
 
-	if _, err = readAllBytes(headerSubsystemOffsetLen, f.r); err != nil {
-		return
+	buf, err := br.readFull(p)
+	if err != nil {
+		return 0, nil, err
 	}
-
-	if buf, err = readAllBytes(headerFlagLen, f.r); err != nil {
-		return
+	if err := br.skipPad(p); err != nil {
+		return 0, nil, err
 	}
+	return el.Type, buf, nil
+}
 
-	byteOrder := string(buf[2:4])
-	if byteOrder == "MI" {
-		h.Endianess = binary.BigEndian
-	} else if byteOrder == "IM" {
-		h.Endianess = binary.LittleEndian
-	} else {
-		return fmt.Errorf("invalid byte order setting: %s", byteOrder)
+// skipPad discards the padding bytes that follow an n-byte element payload
+// up to the next 8-byte boundary.
+func (br *binaryReader) skipPad(n int) error {
+	if rem := pad8(int64(n)) - int64(n); rem > 0 {
+		_, err := io.CopyN(ioutil.Discard, br.r, rem)
+		return err
 	}
-
 	return nil
 }
 
-func readAllBytes(p int, rdr io.Reader) (buf []byte, err error) {
+func readElement(bo binary.ByteOrder, r io.Reader) (el *Element, err error) {
+	br := newBinaryReader(bo, r)
+
 	var (
-		n int
-		r []byte
+		p     int
+		small []byte
 	)
-
-	for p > 0 {
-		r = make([]byte, p)
-		n, err = rdr.Read(r)
-
-		if err != nil {
-			if err.Error() == "EOF" {
-				if p-n == 0 {
-					return append(buf, r[:n]...), nil
-				}
-			}
-			return
-		}
-
-		buf = append(buf, r[:n]...)
-		p -= n
-	}
-	return
-}
-
-func (f *File) readUint32() (uint32, error) {
-	buf, err := readAllBytes(4, f.r)
-	if err != nil {
-		return uint32(0), err
+	if el, p, small, err = br.readTag(); err != nil {
+		return nil, err
 	}
-	return f.Header.Endianess.Uint32(buf), nil
-}
-
-// ReadElement reads a single Element from a file's reader
-func (f *File) ReadElement() (el *Element, err error) {
-	return readElement(f.Header.Endianess, f.r)
-}
-
-func readElement(bo binary.ByteOrder, r io.Reader) (el *Element, err error) {
-	var p int
-	el, p, err = readTag(bo, r)
 
-	// if small element, p will be 0, bail early
-	if p == 0 {
-		return
+	if small != nil {
+		if el.Type == DTmiMATRIX {
+			return miMatrix(bo, small[:p])
+		}
+		el.Value, err = parse(el.Type, bo, small[:p])
+		return el, err
 	}
 
-	var buf []byte
-	if el.Type != DTmiCOMPRESSED {
-		// read data
-		if buf, err = readAllBytes(int(p), r); err != nil {
-			return nil, err
-		}
-	} else {
+	if el.Type == DTmiCOMPRESSED {
 		// data is compressed, use zlib reader
 		cr, err := zlib.NewReader(r)
 		if err != nil {
@@ -249,152 +228,397 @@ func readElement(bo binary.ByteOrder, r io.Reader) (el *Element, err error) {
 		return readElement(bo, cr)
 	}
 
-	el.Value, err = parse(el.Type, bo, buf)
-	return
-}
-
-func readTag(bo binary.ByteOrder, r io.Reader) (el *Element, len int, err error) {
-	var buf []byte
-	if buf, err = readAllBytes(8, r); err != nil {
-		return
+	buf, err := br.readFull(p)
+	if err != nil {
+		return nil, err
 	}
-
-	// handle small type
-	if buf[0] != 0 && buf[1] != 0 {
-		len = int(bo.Uint16(buf[:2]))
-		el = &Element{Type: DataType(bo.Uint16(buf[1:3]))}
-		fmt.Println("SMOL: ", el.Type.String(), len, buf)
-		el.Value, err = parse(el.Type, bo, buf[3:])
-		return
+	if err := br.skipPad(p); err != nil {
+		return nil, err
 	}
 
-	el = &Element{Type: DataType(bo.Uint32(buf[:4]))}
-	len = int(bo.Uint32(buf[4:]))
-	fmt.Println("read tag", el.Type.String(), len, buf)
-	return
+	if el.Type == DTmiMATRIX {
+		return miMatrix(bo, buf)
+	}
+	el.Value, err = parse(el.Type, bo, buf)
+	return el, err
 }
 
 func parse(t DataType, bo binary.ByteOrder, data []byte) (interface{}, error) {
 	switch t {
 	case DTmiINT8:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("matlab: %s element too short: %d bytes", t, len(data))
+		}
 		return int(data[0]), nil
 	case DTmiMATRIX:
 		return miMatrix(bo, data)
 	case DTmiUINT32:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("matlab: %s element too short: %d bytes", t, len(data))
+		}
 		return bo.Uint32(data), nil
 	case DTmiINT32:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("matlab: %s element too short: %d bytes", t, len(data))
+		}
 		return int32(bo.Uint32(data)), nil
 	default:
 		return nil, fmt.Errorf("cannot parse data type: %s", t)
 	}
 }
 
-func miMatrix(bo binary.ByteOrder, data []byte) (val interface{}, err error) {
-	r := bytes.NewBuffer(data)
+// miMatrix decodes the body of a miMATRIX element: array flags, dimensions,
+// and name, followed by class-specific data.
+func miMatrix(bo binary.ByteOrder, data []byte) (*Element, error) {
+	br := newBinaryReader(bo, bytes.NewReader(data))
 
-	complex, class, err := arrayFlags(bo, r)
+	flags, err := arrayFlags(br)
 	if err != nil {
-		return
+		return nil, err
 	}
-	fmt.Println(complex, class.String())
 
-	dim, err := dimensionsArray(bo, r)
+	dims, err := dimensionsArray(br)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	name, err := arrayName(bo, r)
+	name, err := arrayName(br)
 	if err != nil {
-		return
+		return nil, err
 	}
-	fmt.Println(name, dim)
-	return nil, fmt.Errorf("not finished")
-
-	// var els []interface{}
-	// for {
-	// 	el, err := readElement(bo, r)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	els = append(els, el)
-	// }
-	// return nil, fmt.Errorf("cannot parse miMatrix")
-}
 
-func arrayFlags(bo binary.ByteOrder, r io.Reader) (complex bool, class mxClass, err error) {
-	fmt.Println("read array flags")
-	el, p, err := readTag(bo, r)
-	if el.Type != DTmiUINT32 {
-		err = fmt.Errorf("invalid matrix")
-		return
+	el := &Element{
+		Type:    DTmiMATRIX,
+		Name:    name,
+		Dims:    dims,
+		Class:   flags.class,
+		Global:  flags.global,
+		Logical: flags.logical,
+	}
+
+	switch flags.class {
+	case mxCELL:
+		el.Value, err = cellData(br, dims)
+	case mxSTRUCT:
+		el.Value, err = structData(br, dims)
+	case mxCHAR:
+		el.Value, err = charData(br)
+	case mxSPARSE:
+		el.Value, err = sparseData(br, flags)
+	default:
+		el.Value, err = numericData(br, flags)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return el, nil
+}
 
-	buf := make([]byte, p)
-	// // read array flags
-	if _, err = r.Read(buf); err != nil {
+// matrixFlags is the decoded form of a matrix's Array Flags sub-element.
+type matrixFlags struct {
+	class   mxClass
+	complex bool
+	global  bool
+	logical bool
+	nzmax   uint32
+}
+
+func arrayFlags(br *binaryReader) (flags matrixFlags, err error) {
+	t, buf, err := br.readDataElement()
+	if err != nil {
 		return
 	}
-	// flags := (buf[0] &&& 0xff00) >>> 8 |> byte
-	// complex, glbl, logical := flags &&& 8, flags &&& 4, flags &&& 2
-	// fmt.Println(p, hex.EncodeToString(buf))
-	// TODO -
-	// complex = 8 & f[2]
-	// fmt.Println(hex.EncodeToString(buf), uint8(buf[3]))
-	fmt.Println(buf, buf[0]&0x00ff)
-	class = mxClass(buf[0] & 0x00ff)
+	if t != DTmiUINT32 {
+		return flags, fmt.Errorf("invalid array flags element type: %s", t)
+	}
+	if len(buf) < 8 {
+		return flags, fmt.Errorf("matlab: array flags element too short: %d bytes", len(buf))
+	}
+
+	word := br.bo.Uint32(buf[0:4])
+	bits := byte(word >> 8)
+
+	flags.class = mxClass(word & 0xff)
+	flags.complex = bits&0x08 != 0
+	flags.global = bits&0x04 != 0
+	flags.logical = bits&0x02 != 0
+	flags.nzmax = br.bo.Uint32(buf[4:8])
 	return
 }
 
-func dimensionsArray(bo binary.ByteOrder, r io.Reader) ([]int32, error) {
-	fmt.Println("dimensions array")
-	el, p, err := readTag(bo, r)
+func dimensionsArray(br *binaryReader) ([]int32, error) {
+	t, buf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+	if t != DTmiINT32 {
+		return nil, fmt.Errorf("invalid dimensions array element type: %s", t)
+	}
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("matlab: dimensions array element has misaligned length: %d bytes", len(buf))
+	}
+
+	dims := make([]int32, len(buf)/4)
+	for i := range dims {
+		dims[i] = int32(br.bo.Uint32(buf[i*4:]))
+	}
+	return dims, nil
+}
+
+func arrayName(br *binaryReader) (string, error) {
+	_, buf, err := br.readDataElement()
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// numElements returns the number of scalar elements described by dims,
+// rejecting negative dimensions rather than let callers pass a negative
+// length on to make().
+func numElements(dims []int32) (int, error) {
+	n := 1
+	for _, d := range dims {
+		if d < 0 {
+			return 0, fmt.Errorf("matlab: invalid negative dimension: %d", d)
+		}
+		n *= int(d)
+	}
+	return n, nil
+}
+
+// numericData decodes the data sub-element(s) of a numeric matrix,
+// honoring the logical and complex array flags.
+func numericData(br *binaryReader, flags matrixFlags) (interface{}, error) {
+	_, realBuf, err := br.readDataElement()
 	if err != nil {
 		return nil, err
 	}
-	if el.Type != DTmiINT32 {
-		return nil, fmt.Errorf("invalid data type")
+
+	if flags.logical {
+		return boolData(realBuf), nil
 	}
 
-	// fmt.Println("NO MOAR TAGS", el.Type.String(), p)
-	buf := make([]byte, p)
-	if _, err := r.Read(buf); err != nil {
+	real := numericData1(flags.class, br.bo, realBuf)
+	if !flags.complex {
+		return real, nil
+	}
+
+	_, imagBuf, err := br.readDataElement()
+	if err != nil {
 		return nil, err
 	}
+	return &Complex{Real: real, Imag: numericData1(flags.class, br.bo, imagBuf)}, nil
+}
 
-	dimsr := bytes.NewBuffer(buf)
-	sBuf := make([]byte, 4)
-	dim := make([]int32, p/4)
-	for i := 0; i < p/4; i++ {
-		if _, err := dimsr.Read(sBuf); err != nil {
-			return nil, err
+func boolData(buf []byte) []bool {
+	out := make([]bool, len(buf))
+	for i, b := range buf {
+		out[i] = b != 0
+	}
+	return out
+}
+
+// numericData1 decodes a single real (or imaginary) numeric sub-element
+// into a typed, row-major Go slice matching class.
+func numericData1(class mxClass, bo binary.ByteOrder, buf []byte) interface{} {
+	switch class {
+	case mxDOUBLE:
+		out := make([]float64, len(buf)/8)
+		for i := range out {
+			out[i] = math.Float64frombits(bo.Uint64(buf[i*8:]))
+		}
+		return out
+	case mxSINGLE:
+		out := make([]float32, len(buf)/4)
+		for i := range out {
+			out[i] = math.Float32frombits(bo.Uint32(buf[i*4:]))
+		}
+		return out
+	case mxINT8:
+		out := make([]int8, len(buf))
+		for i, b := range buf {
+			out[i] = int8(b)
+		}
+		return out
+	case mxUINT8:
+		out := make([]uint8, len(buf))
+		copy(out, buf)
+		return out
+	case mxINT16:
+		out := make([]int16, len(buf)/2)
+		for i := range out {
+			out[i] = int16(bo.Uint16(buf[i*2:]))
+		}
+		return out
+	case mxUINT16:
+		out := make([]uint16, len(buf)/2)
+		for i := range out {
+			out[i] = bo.Uint16(buf[i*2:])
+		}
+		return out
+	case mxINT32:
+		out := make([]int32, len(buf)/4)
+		for i := range out {
+			out[i] = int32(bo.Uint32(buf[i*4:]))
+		}
+		return out
+	case mxUINT32:
+		out := make([]uint32, len(buf)/4)
+		for i := range out {
+			out[i] = bo.Uint32(buf[i*4:])
+		}
+		return out
+	case mxINT64:
+		out := make([]int64, len(buf)/8)
+		for i := range out {
+			out[i] = int64(bo.Uint64(buf[i*8:]))
+		}
+		return out
+	case mxUINT64:
+		out := make([]uint64, len(buf)/8)
+		for i := range out {
+			out[i] = bo.Uint64(buf[i*8:])
 		}
-		dim[i] = int32(bo.Uint32(sBuf))
+		return out
+	default:
+		return buf
 	}
-	fmt.Println(dim)
-	return dim, nil
 }
 
-func arrayName(bo binary.ByteOrder, r io.Reader) (string, error) {
-	fmt.Println("array name")
-	_, p, err := readTag(bo, r)
+// charData decodes an mxCHAR matrix's data sub-element, supporting both the
+// explicit UTF-8/16/32 element types and the classic UINT16-encoded form
+// MATLAB itself emits.
+func charData(br *binaryReader) (string, error) {
+	t, buf, err := br.readDataElement()
 	if err != nil {
 		return "", err
 	}
 
-	// if el.Type != DTmiINT8 {
-	// 	return "", fmt.Errorf("invalid data type")
-	// }
-	// dimsr := bytes.NewBuffer(buf)
-	// sBuf := make([]byte, 4)
-	// dim := make([]byte, p/4)
-	// for i := 0; i < p/4; i++ {
-	// 	if _, err := dimsr.Read(sBuf); err != nil {
-	// 		return nil, err
-	// 	}
-	// 	dim[i] = int32(bo.Uint32(sBuf))
-	// }
-	data, err := readAllBytes(p, r)
-	return string(data), err
+	switch t {
+	case DTmiUTF8, DTmiINT8, DTmiUINT8:
+		return string(buf), nil
+	case DTmiUTF16, DTmiUINT16:
+		u16 := make([]uint16, len(buf)/2)
+		for i := range u16 {
+			u16[i] = br.bo.Uint16(buf[i*2:])
+		}
+		return string(utf16.Decode(u16)), nil
+	case DTmiUTF32, DTmiUINT32:
+		runes := make([]rune, len(buf)/4)
+		for i := range runes {
+			runes[i] = rune(br.bo.Uint32(buf[i*4:]))
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("matlab: unsupported character encoding: %s", t)
+	}
+}
+
+// cellData recursively reads the N = numElements(dims) sub-elements that
+// make up an mxCELL array.
+func cellData(br *binaryReader, dims []int32) ([]*Element, error) {
+	n, err := numElements(dims)
+	if err != nil {
+		return nil, err
+	}
+
+	els := make([]*Element, n)
+	for i := range els {
+		el, err := readElement(br.bo, br.r)
+		if err != nil {
+			return nil, err
+		}
+		els[i] = el
+	}
+	return els, nil
+}
+
+// structData reads an mxSTRUCT array's Field Name Length and Field Names
+// sub-elements, then one element per field (per array element, field-major).
+func structData(br *binaryReader, dims []int32) (*Struct, error) {
+	t, lenBuf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+	if t != DTmiINT32 || len(lenBuf) != 4 {
+		return nil, fmt.Errorf("invalid field name length element")
+	}
+	fieldNameLength := int(br.bo.Uint32(lenBuf))
+	if fieldNameLength <= 0 {
+		return nil, fmt.Errorf("matlab: invalid field name length: %d", fieldNameLength)
+	}
+
+	_, namesBuf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	for i := 0; i+fieldNameLength <= len(namesBuf); i += fieldNameLength {
+		name := string(bytes.TrimRight(namesBuf[i:i+fieldNameLength], "\x00"))
+		order = append(order, name)
+	}
+
+	n, err := numElements(dims)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("matlab: struct arrays with more than one element are not supported")
+	}
+
+	s := &Struct{Fields: make(map[string]*Element, len(order)), Order: order}
+	for i := 0; i < n; i++ {
+		for _, name := range order {
+			el, err := readElement(br.bo, br.r)
+			if err != nil {
+				return nil, err
+			}
+			s.Fields[name] = el
+		}
+	}
+	return s, nil
+}
+
+// sparseData reads the ir, jc, pr, and (if the array is complex) pi
+// sub-elements of an mxSPARSE array into compressed-sparse-column form.
+func sparseData(br *binaryReader, flags matrixFlags) (*Sparse, error) {
+	_, irBuf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+	_, jcBuf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+	_, prBuf, err := br.readDataElement()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sparse{
+		RowIdx: int32Data(br.bo, irBuf),
+		ColPtr: int32Data(br.bo, jcBuf),
+		Real:   numericData1(mxDOUBLE, br.bo, prBuf),
+	}
+
+	if flags.complex {
+		_, piBuf, err := br.readDataElement()
+		if err != nil {
+			return nil, err
+		}
+		s.Imag = numericData1(mxDOUBLE, br.bo, piBuf)
+	}
+	return s, nil
+}
+
+func int32Data(bo binary.ByteOrder, buf []byte) []int32 {
+	out := make([]int32, len(buf)/4)
+	for i := range out {
+		out[i] = int32(bo.Uint32(buf[i*4:]))
+	}
+	return out
 }
 
 type mxClass uint8
@@ -456,11 +680,3 @@ const (
 	mxUINT64          // 64-bit, unsigned integer
 )
 
-func writeHeader(w io.Writer, h *Header) error {
-	return fmt.Errorf("not finished")
-}
-
-// WriteElement writes a single element to a file's writer
-func (f *File) WriteElement(e *Element) error {
-	return fmt.Errorf("not finished")
-}
@@ -0,0 +1,418 @@
+package matlab
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// NewFileWriter creates a File ready to write a .mat file to w, writing the
+// 128-byte header immediately.
+func NewFileWriter(w io.Writer, h *Header) (*File, error) {
+	if err := writeHeader(w, h); err != nil {
+		return nil, err
+	}
+	return &File{Header: h, w: w}, nil
+}
+
+// WriteElement writes e as a top-level element to a file's writer.
+func (f *File) WriteElement(e *Element) error {
+	buf, err := e.Bytes(f.Header.Endianess)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(buf)
+	return err
+}
+
+// WriteCompressed writes e as a miCOMPRESSED element, deflating its
+// serialized form with zlib.
+func (f *File) WriteCompressed(e *Element) error {
+	inner, err := e.Bytes(f.Header.Endianess)
+	if err != nil {
+		return err
+	}
+
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	if _, err := zw.Write(inner); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return writeTag(f.w, f.Header.Endianess, DTmiCOMPRESSED, deflated.Bytes())
+}
+
+// writeHeader emits the 128-byte .mat file header: the 116-byte
+// description text (space-padded), the zeroed 8-byte subsystem offset, and
+// the 4-byte version/endianness flag.
+func writeHeader(w io.Writer, h *Header) error {
+	text := fmt.Sprintf("MATLAB %s MAT-file Platform: %s, Created on: %s", h.Level, h.Platform, h.Created.Format(time.ANSIC))
+	if len(text) > headerTextLen {
+		return fmt.Errorf("matlab: header description too long: %d bytes", len(text))
+	}
+
+	buf := bytes.Repeat([]byte(" "), headerTextLen)
+	copy(buf, text)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(make([]byte, headerSubsystemOffsetLen)); err != nil {
+		return err
+	}
+
+	flags := make([]byte, headerFlagLen)
+	h.Endianess.PutUint16(flags[0:2], 0x0100)
+	if h.Endianess == binary.BigEndian {
+		copy(flags[2:4], "MI")
+	} else {
+		copy(flags[2:4], "IM")
+	}
+	_, err := w.Write(flags)
+	return err
+}
+
+// writeTag writes a single element - its tag and payload - using the
+// small-element form when payload is 4 bytes or fewer, and the normal form
+// (padded to an 8-byte boundary) otherwise.
+func writeTag(w io.Writer, bo binary.ByteOrder, t DataType, payload []byte) error {
+	if len(payload) <= 4 {
+		buf := make([]byte, 8)
+		bo.PutUint32(buf[0:4], uint32(t)|uint32(len(payload))<<16)
+		copy(buf[4:8], payload)
+		_, err := w.Write(buf)
+		return err
+	}
+
+	head := make([]byte, 8)
+	bo.PutUint32(head[0:4], uint32(t))
+	bo.PutUint32(head[4:8], uint32(len(payload)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	if rem := pad8(int64(len(payload))) - int64(len(payload)); rem > 0 {
+		_, err := w.Write(make([]byte, rem))
+		return err
+	}
+	return nil
+}
+
+// Bytes serializes e into the on-disk form of a single MAT-file element -
+// tag, payload, and padding - symmetric with the decoding miMatrix
+// performs. Only elements with Type == DTmiMATRIX (as produced by miMatrix
+// or Marshal) are supported.
+func (e *Element) Bytes(bo binary.ByteOrder) ([]byte, error) {
+	if e.Type != DTmiMATRIX {
+		return nil, fmt.Errorf("matlab: cannot serialize element of type %s", e.Type)
+	}
+
+	body, err := e.matrixBytes(bo)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeTag(&buf, bo, DTmiMATRIX, body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Element) matrixBytes(bo binary.ByteOrder) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := writeArrayFlags(&buf, bo, e); err != nil {
+		return nil, err
+	}
+	if err := writeDimensionsArray(&buf, bo, e.Dims); err != nil {
+		return nil, err
+	}
+	if err := writeTag(&buf, bo, DTmiINT8, []byte(e.Name)); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch e.Class {
+	case mxCELL:
+		err = writeCellData(&buf, bo, e.Value)
+	case mxSTRUCT:
+		err = writeStructData(&buf, bo, e.Value)
+	case mxCHAR:
+		err = writeCharData(&buf, bo, e.Value)
+	case mxSPARSE:
+		err = writeSparseData(&buf, bo, e.Value)
+	default:
+		err = writeNumericData(&buf, bo, e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeArrayFlags(w io.Writer, bo binary.ByteOrder, e *Element) error {
+	var bits byte
+	if isComplex(e.Value) {
+		bits |= 0x08
+	}
+	if e.Global {
+		bits |= 0x04
+	}
+	if e.Logical {
+		bits |= 0x02
+	}
+
+	buf := make([]byte, 8)
+	bo.PutUint32(buf[0:4], uint32(e.Class)|uint32(bits)<<8)
+	return writeTag(w, bo, DTmiUINT32, buf)
+}
+
+func isComplex(v interface{}) bool {
+	switch t := v.(type) {
+	case *Complex:
+		return true
+	case *Sparse:
+		return t.Imag != nil
+	default:
+		return false
+	}
+}
+
+func writeDimensionsArray(w io.Writer, bo binary.ByteOrder, dims []int32) error {
+	buf := make([]byte, 4*len(dims))
+	for i, d := range dims {
+		bo.PutUint32(buf[i*4:], uint32(d))
+	}
+	return writeTag(w, bo, DTmiINT32, buf)
+}
+
+func writeNumericData(w io.Writer, bo binary.ByteOrder, e *Element) error {
+	if e.Logical {
+		b, ok := e.Value.([]bool)
+		if !ok {
+			return fmt.Errorf("matlab: logical element has non-bool value %T", e.Value)
+		}
+		return writeTag(w, bo, DTmiUINT8, boolBytes(b))
+	}
+
+	if c, ok := e.Value.(*Complex); ok {
+		realBuf, t, err := numericBytes(bo, e.Class, c.Real)
+		if err != nil {
+			return err
+		}
+		if err := writeTag(w, bo, t, realBuf); err != nil {
+			return err
+		}
+		imagBuf, _, err := numericBytes(bo, e.Class, c.Imag)
+		if err != nil {
+			return err
+		}
+		return writeTag(w, bo, t, imagBuf)
+	}
+
+	buf, t, err := numericBytes(bo, e.Class, e.Value)
+	if err != nil {
+		return err
+	}
+	return writeTag(w, bo, t, buf)
+}
+
+func boolBytes(v []bool) []byte {
+	out := make([]byte, len(v))
+	for i, b := range v {
+		if b {
+			out[i] = 1
+		}
+	}
+	return out
+}
+
+// numericBytes encodes a typed numeric slice (as produced by numericData1)
+// into its raw bytes, returning the DataType tag it should be written with.
+func numericBytes(bo binary.ByteOrder, class mxClass, value interface{}) ([]byte, DataType, error) {
+	switch v := value.(type) {
+	case []float64:
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			bo.PutUint64(buf[i*8:], math.Float64bits(x))
+		}
+		return buf, DTmiDOUBLE, nil
+	case []float32:
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			bo.PutUint32(buf[i*4:], math.Float32bits(x))
+		}
+		return buf, DTmiSINGLE, nil
+	case []int8:
+		buf := make([]byte, len(v))
+		for i, x := range v {
+			buf[i] = byte(x)
+		}
+		return buf, DTmiINT8, nil
+	case []uint8:
+		buf := make([]byte, len(v))
+		copy(buf, v)
+		return buf, DTmiUINT8, nil
+	case []int16:
+		buf := make([]byte, 2*len(v))
+		for i, x := range v {
+			bo.PutUint16(buf[i*2:], uint16(x))
+		}
+		return buf, DTmiINT16, nil
+	case []uint16:
+		buf := make([]byte, 2*len(v))
+		for i, x := range v {
+			bo.PutUint16(buf[i*2:], x)
+		}
+		return buf, DTmiUINT16, nil
+	case []int32:
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			bo.PutUint32(buf[i*4:], uint32(x))
+		}
+		return buf, DTmiINT32, nil
+	case []uint32:
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			bo.PutUint32(buf[i*4:], x)
+		}
+		return buf, DTmiUINT32, nil
+	case []int64:
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			bo.PutUint64(buf[i*8:], uint64(x))
+		}
+		return buf, DTmiINT64, nil
+	case []uint64:
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			bo.PutUint64(buf[i*8:], x)
+		}
+		return buf, DTmiUINT64, nil
+	default:
+		return nil, 0, fmt.Errorf("matlab: unsupported numeric value type %T for class %s", value, class)
+	}
+}
+
+func writeCharData(w io.Writer, bo binary.ByteOrder, value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("matlab: char element has non-string value %T", value)
+	}
+
+	u16 := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(u16))
+	for i, u := range u16 {
+		bo.PutUint16(buf[i*2:], u)
+	}
+	return writeTag(w, bo, DTmiUTF16, buf)
+}
+
+func writeCellData(w io.Writer, bo binary.ByteOrder, value interface{}) error {
+	els, ok := value.([]*Element)
+	if !ok {
+		return fmt.Errorf("matlab: cell element has non-cell value %T", value)
+	}
+	for _, el := range els {
+		buf, err := el.Bytes(bo)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStructData(w io.Writer, bo binary.ByteOrder, value interface{}) error {
+	st, ok := value.(*Struct)
+	if !ok {
+		return fmt.Errorf("matlab: struct element has non-struct value %T", value)
+	}
+
+	fieldNameLength := 1
+	for _, name := range st.Order {
+		if len(name)+1 > fieldNameLength {
+			fieldNameLength = len(name) + 1
+		}
+	}
+
+	lenBuf := make([]byte, 4)
+	bo.PutUint32(lenBuf, uint32(fieldNameLength))
+	if err := writeTag(w, bo, DTmiINT32, lenBuf); err != nil {
+		return err
+	}
+
+	names := make([]byte, fieldNameLength*len(st.Order))
+	for i, name := range st.Order {
+		copy(names[i*fieldNameLength:], name)
+	}
+	if err := writeTag(w, bo, DTmiINT8, names); err != nil {
+		return err
+	}
+
+	for _, name := range st.Order {
+		buf, err := st.Fields[name].Bytes(bo)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSparseData(w io.Writer, bo binary.ByteOrder, value interface{}) error {
+	sp, ok := value.(*Sparse)
+	if !ok {
+		return fmt.Errorf("matlab: sparse element has non-sparse value %T", value)
+	}
+
+	irBuf := make([]byte, 4*len(sp.RowIdx))
+	for i, x := range sp.RowIdx {
+		bo.PutUint32(irBuf[i*4:], uint32(x))
+	}
+	if err := writeTag(w, bo, DTmiINT32, irBuf); err != nil {
+		return err
+	}
+
+	jcBuf := make([]byte, 4*len(sp.ColPtr))
+	for i, x := range sp.ColPtr {
+		bo.PutUint32(jcBuf[i*4:], uint32(x))
+	}
+	if err := writeTag(w, bo, DTmiINT32, jcBuf); err != nil {
+		return err
+	}
+
+	prBuf, t, err := numericBytes(bo, mxDOUBLE, sp.Real)
+	if err != nil {
+		return err
+	}
+	if err := writeTag(w, bo, t, prBuf); err != nil {
+		return err
+	}
+
+	if sp.Imag != nil {
+		piBuf, _, err := numericBytes(bo, mxDOUBLE, sp.Imag)
+		if err != nil {
+			return err
+		}
+		if err := writeTag(w, bo, t, piBuf); err != nil {
+			return err
+		}
+	}
+	return nil
+}